@@ -0,0 +1,211 @@
+// Copyright 2017 Fabian Kohn. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package profiler defines and manages the basic profiling commands and the
+// web frontend.
+package profiler
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"time"
+)
+
+// ContinuousConfig configures the background continuous-profiling mode
+// enabled via WithContinuous. A zero interval disables collection of the
+// corresponding profile type.
+type ContinuousConfig struct {
+
+	// Sink receives every profile captured by the scheduler
+	Sink Sink
+
+	// CPUDuration is the length of each CPU profile sample
+	CPUDuration time.Duration
+
+	// CPUInterval is the time between the start of successive CPU profile
+	// samples
+	CPUInterval time.Duration
+
+	// HeapInterval is the time between successive heap profile dumps
+	HeapInterval time.Duration
+
+	// GoroutineInterval is the time between successive goroutine profile
+	// dumps
+	GoroutineInterval time.Duration
+
+	// MutexInterval is the time between successive mutex profile dumps
+	MutexInterval time.Duration
+
+	// BlockInterval is the time between successive block profile dumps
+	BlockInterval time.Duration
+
+	// Labels are attached to every captured profile (in addition to
+	// hostname / GOOS / GOARCH, which are populated automatically)
+	Labels map[string]string
+}
+
+// WithContinuous enables continuous background profiling according to cfg.
+// Profiles are captured on their configured interval, staggered by type, and
+// handed off to cfg.Sink. Capture failures are coalesced through the
+// Profiler's errorHandler (see WithErrorHandler).
+//
+// cfg.Sink is required and is validated immediately: captures run from a
+// background goroutine started by Run(), not an HTTP handler, so a nil Sink
+// would otherwise panic uncaught and take down the process the first time a
+// profile fires, arbitrarily long after startup.
+func WithContinuous(cfg ContinuousConfig) func(*Profiler) {
+	if cfg.Sink == nil {
+		panic("profiler: WithContinuous requires a non-nil Sink")
+	}
+	return func(p *Profiler) {
+		p.continuous = &cfg
+	}
+}
+
+// scheduledCapture pairs a configured interval with the capture func it
+// drives, used by runContinuous to compute a per-type stagger offset.
+type scheduledCapture struct {
+	interval time.Duration
+	capture  func(ctx context.Context, labels map[string]string)
+}
+
+// runContinuous starts one scheduling goroutine per configured profile type,
+// each offset by a fraction of its interval (see scheduleContinuous) so that
+// profile types sharing the same interval don't all fire in lockstep. It is
+// called from Run() and returns once the goroutines have been started.
+func (p *Profiler) runContinuous() {
+	cfg := p.continuous
+	labels := p.continuousLabels(cfg.Labels)
+
+	var scheduled []scheduledCapture
+	if cfg.CPUInterval > 0 {
+		scheduled = append(scheduled, scheduledCapture{cfg.CPUInterval, func(ctx context.Context, l map[string]string) {
+			p.captureContinuousCPU(ctx, cfg.CPUDuration, l)
+		}})
+	}
+	if cfg.HeapInterval > 0 {
+		scheduled = append(scheduled, scheduledCapture{cfg.HeapInterval, func(ctx context.Context, l map[string]string) {
+			p.captureContinuousLookup(ctx, "heap", l)
+		}})
+	}
+	if cfg.GoroutineInterval > 0 {
+		scheduled = append(scheduled, scheduledCapture{cfg.GoroutineInterval, func(ctx context.Context, l map[string]string) {
+			p.captureContinuousLookup(ctx, "goroutine", l)
+		}})
+	}
+	if cfg.MutexInterval > 0 {
+		scheduled = append(scheduled, scheduledCapture{cfg.MutexInterval, func(ctx context.Context, l map[string]string) {
+			p.captureContinuousLookup(ctx, "mutex", l)
+		}})
+	}
+	if cfg.BlockInterval > 0 {
+		scheduled = append(scheduled, scheduledCapture{cfg.BlockInterval, func(ctx context.Context, l map[string]string) {
+			p.captureContinuousLookup(ctx, "block", l)
+		}})
+	}
+
+	for i, s := range scheduled {
+		offset := s.interval * time.Duration(i) / time.Duration(len(scheduled))
+		go p.scheduleContinuous(s.interval, offset, labels, s.capture)
+	}
+}
+
+// scheduleContinuous waits out offset once, then ticks every interval and
+// invokes capture. offset places this profile type at its own fraction of
+// the interval (assigned by runContinuous based on scheduling order), so
+// that profile types configured with the same interval don't all capture
+// at the same instant and hit the sink simultaneously.
+func (p *Profiler) scheduleContinuous(interval, offset time.Duration, labels map[string]string, capture func(ctx context.Context, labels map[string]string)) {
+	if offset > 0 {
+		time.Sleep(offset)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		capture(context.Background(), labels)
+	}
+}
+
+// captureContinuousCPU captures a CPU profile of the given duration and
+// writes it to the configured sink. CPU profiling is a single process-global
+// resource, shared with the on-demand Profile handler, so capture is
+// serialized through p.cpuProfileMu: if a /profile request is in flight when
+// this fires, the capture simply waits for it to finish instead of failing.
+func (p *Profiler) captureContinuousCPU(ctx context.Context, duration time.Duration, labels map[string]string) {
+	if duration <= 0 {
+		duration = 10 * time.Second
+	}
+
+	p.cpuProfileMu.Lock()
+	defer p.cpuProfileMu.Unlock()
+
+	var buf bytes.Buffer
+	pprof.Do(ctx, pprofLabels(labels), func(ctx context.Context) {
+		if err := pprof.StartCPUProfile(&buf); err != nil {
+			p.errorHandler(err)
+			return
+		}
+		time.Sleep(duration)
+		pprof.StopCPUProfile()
+
+		if err := p.continuous.Sink.Write(ctx, "cpu", time.Now(), labels, &buf); err != nil {
+			p.errorHandler(err)
+		}
+	})
+}
+
+// captureContinuousLookup captures the named lookup profile (e.g. "heap",
+// "goroutine", "mutex", "block") and writes it to the configured sink.
+func (p *Profiler) captureContinuousLookup(ctx context.Context, name string, labels map[string]string) {
+	prof := pprof.Lookup(name)
+	if prof == nil {
+		return
+	}
+
+	var buf bytes.Buffer
+	pprof.Do(ctx, pprofLabels(labels), func(ctx context.Context) {
+		if err := prof.WriteTo(&buf, 0); err != nil {
+			p.errorHandler(err)
+			return
+		}
+		if err := p.continuous.Sink.Write(ctx, name, time.Now(), labels, &buf); err != nil {
+			p.errorHandler(err)
+		}
+	})
+}
+
+// continuousLabels merges the caller-provided labels with the hostname
+// (unless the caller already set one) and the goos / goarch platform labels
+// attached to every captured profile. No service or version label is added
+// unless the caller supplies one.
+func (p *Profiler) continuousLabels(custom map[string]string) map[string]string {
+	labels := make(map[string]string, len(custom)+3)
+	for k, v := range custom {
+		labels[k] = v
+	}
+	if _, ok := labels["hostname"]; !ok {
+		if host, err := os.Hostname(); err == nil {
+			labels["hostname"] = host
+		}
+	}
+	labels["goos"] = runtime.GOOS
+	labels["goarch"] = runtime.GOARCH
+	return labels
+}
+
+// pprofLabels converts a plain label map into a pprof.LabelSet suitable for
+// pprof.Do, so that continuously captured samples carry the same labels that
+// are recorded alongside the profile by the sink.
+func pprofLabels(labels map[string]string) pprof.LabelSet {
+	kv := make([]string, 0, 2*len(labels))
+	for k, v := range labels {
+		kv = append(kv, k, v)
+	}
+	return pprof.Labels(kv...)
+}
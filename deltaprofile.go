@@ -0,0 +1,84 @@
+// Copyright 2017 Fabian Kohn. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package profiler defines and manages the basic profiling commands and the
+// web frontend.
+package profiler
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"runtime/pprof"
+	"strconv"
+	"time"
+
+	"github.com/fako1024/profiler/internal/profile"
+)
+
+// namedProfile returns an http.HandlerFunc serving the named runtime/pprof
+// lookup profile (e.g. "allocs", "block", "mutex", "threadcreate"). If
+// "?seconds=N" is set, the profile is instead captured twice (at t=0 and
+// t=N) and the diff between the two snapshots is returned, matching the
+// delta mode of the standard library's net/http/pprof handlers, and the
+// response gets the same Content-Disposition treatment as every other binary
+// profile response. Without "?seconds=", serving is delegated to
+// handler(name) instead, which applies its own Content-Disposition / debug
+// handling.
+func namedProfile(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if sec, err := strconv.ParseInt(r.FormValue("seconds"), 10, 64); err == nil && sec > 0 {
+			prof := pprof.Lookup(name)
+			if prof == nil {
+				serveError(w, http.StatusNotFound, "Unknown profile")
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/octet-stream")
+			setAttachment(w, name+".pprof")
+			if err := writeDeltaProfile(w, prof, time.Duration(sec)*time.Second); err != nil {
+				serveError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to compute delta profile: %s", err))
+			}
+			return
+		}
+
+		handler(name).ServeHTTP(w, r)
+	}
+}
+
+// writeDeltaProfile captures prof at t=0 and again at t=0+duration, then
+// writes the difference between the two snapshots to w. It uses the same
+// sleep helper as Profile/Trace, so a disconnected client aborts the wait
+// early instead of holding the goroutine for the full duration.
+func writeDeltaProfile(w http.ResponseWriter, prof *pprof.Profile, duration time.Duration) error {
+	var before bytes.Buffer
+	if err := prof.WriteTo(&before, 0); err != nil {
+		return err
+	}
+
+	sleep(w, duration)
+
+	var after bytes.Buffer
+	if err := prof.WriteTo(&after, 0); err != nil {
+		return err
+	}
+
+	p0, err := profile.Parse(&before)
+	if err != nil {
+		return fmt.Errorf("failed to parse initial snapshot: %w", err)
+	}
+	p1, err := profile.Parse(&after)
+	if err != nil {
+		return fmt.Errorf("failed to parse final snapshot: %w", err)
+	}
+
+	p0.Scale(-1)
+
+	merged, err := profile.Merge([]*profile.Profile{p0, p1})
+	if err != nil {
+		return fmt.Errorf("failed to merge snapshots: %w", err)
+	}
+
+	return merged.Write(w)
+}
@@ -24,7 +24,6 @@ import (
 
 	"github.com/fako1024/profiler/internal/fetch"
 	"github.com/fako1024/profiler/internal/profile"
-	"github.com/fako1024/profiler/internal/report"
 	"github.com/fako1024/profiler/internal/symbolz"
 )
 
@@ -36,20 +35,24 @@ func Handler(name string) http.Handler {
 type handler string
 
 func (name handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	debug, _ := strconv.Atoi(r.FormValue("debug"))
 	p := pprof.Lookup(string(name))
 	if p == nil {
-		w.WriteHeader(404)
-		fmt.Fprintf(w, "Unknown profile: %s\n", name)
+		serveError(w, http.StatusNotFound, "Unknown profile")
 		return
 	}
 	gc, _ := strconv.Atoi(r.FormValue("gc"))
 	if name == "heap" && gc > 0 {
 		runtime.GC()
 	}
+
+	if debug == 0 {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		setAttachment(w, string(name)+".pprof")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
 	p.WriteTo(w, debug)
-	return
 }
 
 // Index responds with the pprof-formatted profile named by the request.
@@ -59,14 +62,26 @@ func (name handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func (p *Profiler) Index(w http.ResponseWriter, r *http.Request) {
 
 	if r.URL.Path != "/" {
-		handler(strings.TrimPrefix(r.URL.Path, "/")).ServeHTTP(w, r)
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		if !p.profileAllowed(name) {
+			serveError(w, http.StatusForbidden, "Profile not permitted")
+			return
+		}
+		handler(name).ServeHTTP(w, r)
 		return
 	}
 
-	profiles := pprof.Profiles()
 	indexTmpl := template.Must(template.New("index").Parse(p.htmlTemplate))
 
-	if err := indexTmpl.Execute(w, profiles); err != nil {
+	data := struct {
+		Profiles     []*pprof.Profile
+		ProxyTargets []string
+	}{
+		Profiles:     pprof.Profiles(),
+		ProxyTargets: p.targetNames(),
+	}
+
+	if err := indexTmpl.Execute(w, data); err != nil {
 		log.Print(err)
 	}
 }
@@ -90,15 +105,25 @@ func (p *Profiler) Profile(w http.ResponseWriter, r *http.Request) {
 	binary, _ := strconv.ParseBool(r.FormValue("binary"))
 	cum, _ := strconv.ParseBool(r.FormValue("cum"))
 
+	if p.durationExceedsWriteTimeout(time.Duration(sec) * time.Second) {
+		w.Header().Set("X-Go-Pprof", "1")
+		serveError(w, http.StatusBadRequest, fmt.Sprintf("profile duration %ds exceeds server's WriteTimeout (%s); would be truncated", sec, p.server.WriteTimeout))
+		return
+	}
+
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 
 	var buf bytes.Buffer
 
+	// CPU profiling is a single process-global resource, shared with any
+	// continuous CPU capture started via WithContinuous; serialize against it
+	// so the two never call StartCPUProfile concurrently.
+	p.cpuProfileMu.Lock()
+	defer p.cpuProfileMu.Unlock()
+
 	if err := pprof.StartCPUProfile(&buf); err != nil {
 		// StartCPUProfile failed, so no writes yet.
-		// Enforce header to text content and send error code.
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Could not enable CPU profiling: %s\n", err)
+		serveError(w, http.StatusInternalServerError, fmt.Sprintf("Could not enable CPU profiling: %s", err))
 		return
 	}
 	sleep(w, time.Duration(sec)*time.Second)
@@ -110,36 +135,30 @@ func (p *Profiler) Profile(w http.ResponseWriter, r *http.Request) {
 
 		// Set binary content type and send the data
 		w.Header().Set("Content-Type", "application/octet-stream")
+		setAttachment(w, "profile.pprof")
 		w.Write(buf.Bytes())
 	} else {
 
 		// Parse profile
 		prof, err := profile.Parse(&buf)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "Failed to parse profile: %s\n", err)
+			serveError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to parse profile: %s", err))
 			return
 		}
 
 		// Symbolize profile using symbol lookup call to self
 		if err = symbolz.Symbolize("http://"+r.Host+"/symbol", fetch.PostURL, prof); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "Failed to symbolize profile: %s\n", err)
+			serveError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to symbolize profile: %s", err))
 		}
 
-		// Create a new profile report
-		rpt := report.NewDefault(prof, report.Options{
-			OutputFormat:   report.Text,
-			CumSort:        cum,
-			PrintAddresses: true,
-		})
-
-		// Genrate the report, reusing the existing buffer
-		buf.Reset()
-		report.Generate(&buf, rpt, nil)
-
-		// Send the buffer contents
-		w.Write(buf.Bytes())
+		// Render the report in the format requested via "?format=" (defaults to
+		// "text"), honoring any "?nodecount=", "?nodefraction=", "?edgefraction="
+		// and "?focus=" / "?ignore=" filters; renderReport's errors never
+		// include the caller-supplied "?focus=", "?ignore=" or "?format="
+		// values, so they are safe to pass to serveError.
+		if err := p.renderReport(w, r, prof, cum); err != nil {
+			serveError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to render report: %s", err))
+		}
 	}
 }
 
@@ -152,15 +171,20 @@ func (p *Profiler) Trace(w http.ResponseWriter, r *http.Request) {
 		sec = 1
 	}
 
+	if p.durationExceedsWriteTimeout(time.Duration(sec * float64(time.Second))) {
+		w.Header().Set("X-Go-Pprof", "1")
+		serveError(w, http.StatusBadRequest, fmt.Sprintf("trace duration %.3fs exceeds server's WriteTimeout (%s); would be truncated", sec, p.server.WriteTimeout))
+		return
+	}
+
 	// Set Content Type assuming trace.Start will work,
 	// because if it does it starts writing.
 	w.Header().Set("Content-Type", "application/octet-stream")
+	setAttachment(w, "trace.out")
 	if err := trace.Start(w); err != nil {
-		// trace.Start failed, so no writes yet.
-		// Can change header back to text content and send error code.
-		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Could not enable tracing: %s\n", err)
+		// trace.Start failed, so no writes yet. serveError resets Content-Type
+		// and clears the Content-Disposition set above.
+		serveError(w, http.StatusInternalServerError, fmt.Sprintf("Could not enable tracing: %s", err))
 		return
 	}
 	sleep(w, time.Duration(sec*float64(time.Second)))
@@ -216,6 +240,54 @@ func (p *Profiler) Symbol(w http.ResponseWriter, r *http.Request) {
 	w.Write(buf.Bytes())
 }
 
+// Allocs responds with a sampling of all past memory allocations.
+// The package initialization registers it as /allocs. Accepts "?seconds=N"
+// to return a delta profile between two snapshots N seconds apart.
+func (p *Profiler) Allocs(w http.ResponseWriter, r *http.Request) {
+	if !p.profileAllowed("allocs") {
+		serveError(w, http.StatusForbidden, "Profile not permitted")
+		return
+	}
+	namedProfile("allocs")(w, r)
+}
+
+// Block responds with a sampling of goroutine blocking events.
+// The package initialization registers it as /block. Accepts "?seconds=N"
+// to return a delta profile between two snapshots N seconds apart.
+// Requires WithBlockProfileRate to have been set for any events to appear.
+func (p *Profiler) Block(w http.ResponseWriter, r *http.Request) {
+	if !p.profileAllowed("block") {
+		serveError(w, http.StatusForbidden, "Profile not permitted")
+		return
+	}
+	namedProfile("block")(w, r)
+}
+
+// Mutex responds with a sampling of mutex contention events.
+// The package initialization registers it as /mutex. Accepts "?seconds=N"
+// to return a delta profile between two snapshots N seconds apart.
+// Requires WithMutexProfileFraction to have been set for any events to
+// appear.
+func (p *Profiler) Mutex(w http.ResponseWriter, r *http.Request) {
+	if !p.profileAllowed("mutex") {
+		serveError(w, http.StatusForbidden, "Profile not permitted")
+		return
+	}
+	namedProfile("mutex")(w, r)
+}
+
+// Threadcreate responds with a sampling of stack traces that led to the
+// creation of new OS threads. The package initialization registers it as
+// /threadcreate. Accepts "?seconds=N" to return a delta profile between two
+// snapshots N seconds apart.
+func (p *Profiler) Threadcreate(w http.ResponseWriter, r *http.Request) {
+	if !p.profileAllowed("threadcreate") {
+		serveError(w, http.StatusForbidden, "Profile not permitted")
+		return
+	}
+	namedProfile("threadcreate")(w, r)
+}
+
 func sleep(w http.ResponseWriter, d time.Duration) {
 	var clientGone <-chan bool
 	if cn, ok := w.(http.CloseNotifier); ok {
@@ -0,0 +1,289 @@
+// Copyright 2017 Fabian Kohn. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package profiler defines and manages the basic profiling commands and the
+// web frontend.
+package profiler
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"runtime/metrics"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultMetricsNamespace is the metric name prefix used unless overridden
+// via WithMetricsNamespace
+const defaultMetricsNamespace = "profiler"
+
+// staticRoutes are the fixed, registered handler paths that are recorded
+// as-is in the per-handler metrics section of "/metrics".
+var staticRoutes = map[string]bool{
+	"/":             true,
+	"/profile":      true,
+	"/cmdline":      true,
+	"/symbol":       true,
+	"/trace":        true,
+	"/allocs":       true,
+	"/block":        true,
+	"/mutex":        true,
+	"/threadcreate": true,
+	"/metrics":      true,
+}
+
+// Sample is a single named measurement returned by a user-registered
+// collector (see RegisterCollector)
+type Sample struct {
+	Name   string
+	Value  float64
+	Labels map[string]string
+}
+
+// MetricsOption configures the metrics subsystem enabled via WithMetrics
+type MetricsOption func(*metricsRegistry)
+
+// WithMetricsNamespace sets the prefix prepended to every metric name
+// exposed on "/metrics" (default "profiler")
+func WithMetricsNamespace(namespace string) MetricsOption {
+	return func(m *metricsRegistry) {
+		m.namespace = namespace
+	}
+}
+
+// WithMetrics configures the "/metrics" endpoint, which is otherwise
+// exposed with its default settings even if this option is never used
+func WithMetrics(opts ...MetricsOption) func(*Profiler) {
+	return func(p *Profiler) {
+		for _, opt := range opts {
+			opt(p.metrics)
+		}
+	}
+}
+
+// RegisterCollector registers an additional user-defined metrics source
+// under name. collect is invoked on every scrape of "/metrics"
+func (p *Profiler) RegisterCollector(name string, collect func() []Sample) {
+	p.metrics.mu.Lock()
+	defer p.metrics.mu.Unlock()
+
+	p.metrics.collectors[name] = collect
+}
+
+// handlerStat tracks request count / cumulative latency for a single route,
+// used to populate the per-handler metrics section of "/metrics"
+type handlerStat struct {
+	count    uint64
+	duration time.Duration
+}
+
+// metricsRegistry holds the state backing the "/metrics" endpoint
+type metricsRegistry struct {
+	namespace string
+
+	mu         sync.RWMutex
+	collectors map[string]func() []Sample
+
+	statMu sync.Mutex
+	stats  map[string]*handlerStat
+}
+
+// newMetricsRegistry creates a metricsRegistry with default settings
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		namespace:  defaultMetricsNamespace,
+		collectors: make(map[string]func() []Sample),
+		stats:      make(map[string]*handlerStat),
+	}
+}
+
+// observe records a single completed request against route (a bounded label
+// from routeLabel, not a raw request path), for inclusion in the
+// per-handler metrics section of "/metrics"
+func (m *metricsRegistry) observe(route string, d time.Duration) {
+	m.statMu.Lock()
+	defer m.statMu.Unlock()
+
+	s, ok := m.stats[route]
+	if !ok {
+		s = &handlerStat{}
+		m.stats[route] = s
+	}
+	s.count++
+	s.duration += d
+}
+
+// instrument wraps next, recording a handlerStat for every request it
+// serves
+func (p *Profiler) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		p.metrics.observe(p.routeLabel(r.URL.Path), time.Since(start))
+	})
+}
+
+// routeLabel maps path to a bounded metrics label: one of the Profiler's
+// fixed routes, "/proxy/{name}" for a registered proxy target, the dynamic
+// "/{profile}" path of a registered pprof.Lookup profile, or "other" for
+// everything else. Using the raw, attacker-controlled path as the map key
+// would let an unauthenticated client grow p.metrics.stats without bound by
+// requesting many distinct nonexistent paths.
+func (p *Profiler) routeLabel(path string) string {
+	if staticRoutes[path] {
+		return path
+	}
+
+	if strings.HasPrefix(path, "/proxy/") {
+		name := strings.SplitN(strings.TrimPrefix(path, "/proxy/"), "/", 2)[0]
+		if _, ok := p.target(name); ok {
+			return "/proxy/" + name
+		}
+		return "other"
+	}
+
+	if name := strings.TrimPrefix(path, "/"); name != "" && pprof.Lookup(name) != nil {
+		return path
+	}
+
+	return "other"
+}
+
+// Metrics responds with Go runtime metrics, memory statistics, per-handler
+// request counters/latencies and any collectors registered via
+// RegisterCollector, formatted in Prometheus text exposition format.
+// The package initialization registers it as /metrics.
+func (p *Profiler) Metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	ns := p.metrics.namespace
+
+	p.writeRuntimeMetrics(w, ns)
+	p.writeMemStats(w, ns)
+	p.writeHandlerStats(w, ns)
+	p.writeCollectors(w, ns)
+}
+
+// writeRuntimeMetrics writes every uint64 or float64 metric exposed by
+// runtime/metrics as a Prometheus gauge; histogram metrics are skipped
+func (p *Profiler) writeRuntimeMetrics(w io.Writer, ns string) {
+	descs := metrics.All()
+	samples := make([]metrics.Sample, len(descs))
+	for i, d := range descs {
+		samples[i].Name = d.Name
+	}
+	metrics.Read(samples)
+
+	for _, s := range samples {
+		var v float64
+		switch s.Value.Kind() {
+		case metrics.KindUint64:
+			v = float64(s.Value.Uint64())
+		case metrics.KindFloat64:
+			v = s.Value.Float64()
+		default:
+			continue
+		}
+		fmt.Fprintf(w, "%s_runtime_%s %s\n", ns, sanitizeMetricName(s.Name), formatFloat(v))
+	}
+}
+
+// writeMemStats writes a handful of well-known runtime.MemStats fields,
+// matching the metric names used by the standard Prometheus Go client
+func (p *Profiler) writeMemStats(w io.Writer, ns string) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	fmt.Fprintf(w, "%s_memstats_alloc_bytes %s\n", ns, formatFloat(float64(ms.Alloc)))
+	fmt.Fprintf(w, "%s_memstats_sys_bytes %s\n", ns, formatFloat(float64(ms.Sys)))
+	fmt.Fprintf(w, "%s_memstats_heap_alloc_bytes %s\n", ns, formatFloat(float64(ms.HeapAlloc)))
+	fmt.Fprintf(w, "%s_memstats_heap_inuse_bytes %s\n", ns, formatFloat(float64(ms.HeapInuse)))
+	fmt.Fprintf(w, "%s_memstats_heap_objects %s\n", ns, formatFloat(float64(ms.HeapObjects)))
+	fmt.Fprintf(w, "%s_memstats_gc_count %s\n", ns, formatFloat(float64(ms.NumGC)))
+	fmt.Fprintf(w, "%s_memstats_gc_pause_total_seconds %s\n", ns, formatFloat(float64(ms.PauseTotalNs)/1e9))
+	fmt.Fprintf(w, "%s_goroutines %s\n", ns, formatFloat(float64(runtime.NumGoroutine())))
+}
+
+// writeHandlerStats writes the request count / cumulative latency recorded
+// by instrument for each of the Profiler's own routes
+func (p *Profiler) writeHandlerStats(w io.Writer, ns string) {
+	p.metrics.statMu.Lock()
+	paths := make([]string, 0, len(p.metrics.stats))
+	stats := make(map[string]handlerStat, len(p.metrics.stats))
+	for path, s := range p.metrics.stats {
+		paths = append(paths, path)
+		stats[path] = *s
+	}
+	p.metrics.statMu.Unlock()
+
+	sort.Strings(paths)
+	for _, path := range paths {
+		s := stats[path]
+		fmt.Fprintf(w, "%s_handler_requests_total{handler=%q} %s\n", ns, path, formatFloat(float64(s.count)))
+		fmt.Fprintf(w, "%s_handler_request_duration_seconds_sum{handler=%q} %s\n", ns, path, formatFloat(s.duration.Seconds()))
+		fmt.Fprintf(w, "%s_handler_request_duration_seconds_count{handler=%q} %s\n", ns, path, formatFloat(float64(s.count)))
+	}
+}
+
+// writeCollectors writes every Sample returned by every collector
+// registered via RegisterCollector
+func (p *Profiler) writeCollectors(w io.Writer, ns string) {
+	p.metrics.mu.RLock()
+	defer p.metrics.mu.RUnlock()
+
+	names := make([]string, 0, len(p.metrics.collectors))
+	for name := range p.metrics.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, s := range p.metrics.collectors[name]() {
+			fmt.Fprintf(w, "%s_%s%s %s\n", ns, sanitizeMetricName(s.Name), formatLabels(s.Labels), formatFloat(s.Value))
+		}
+	}
+}
+
+// sanitizeMetricName converts a runtime/metrics-style name (e.g.
+// "/gc/heap/allocs:bytes") into a valid Prometheus metric name fragment
+func sanitizeMetricName(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	replacer := strings.NewReplacer("/", "_", ":", "_", "-", "_", ".", "_")
+	return replacer.Replace(name)
+}
+
+// formatLabels renders a label set as a Prometheus label-value list, e.g.
+// `{a="b",c="d"}`, or the empty string if labels is empty
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%q", k, labels[k])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// formatFloat renders a float64 in Go's shortest %g notation, which uses
+// exponent form (e.g. 1.23456789e+08) for large or very small values
+func formatFloat(v float64) string {
+	return fmt.Sprintf("%g", v)
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 Fabian Kohn. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package profiler
+
+import "testing"
+
+func TestSanitizeMetricName(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"leading slash is stripped", "/gc/heap/allocs:bytes", "gc_heap_allocs_bytes"},
+		{"dashes and dots are replaced", "sched.latencies-ns", "sched_latencies_ns"},
+		{"name without special characters is unchanged", "goroutines", "goroutines"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeMetricName(tt.in); got != tt.want {
+				t.Errorf("sanitizeMetricName(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatLabels(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   string
+	}{
+		{"empty labels render as empty string", nil, ""},
+		{"single label", map[string]string{"a": "b"}, `{a="b"}`},
+		{"multiple labels are sorted by key", map[string]string{"b": "2", "a": "1"}, `{a="1",b="2"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatLabels(tt.labels); got != tt.want {
+				t.Errorf("formatLabels(%v) = %q, want %q", tt.labels, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteLabel(t *testing.T) {
+	p := New(WithProxyTargets(map[string]string{"remote": "127.0.0.1:6061"}))
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"static route is returned as-is", "/metrics", "/metrics"},
+		{"registered proxy target", "/proxy/remote/heap", "/proxy/remote"},
+		{"unregistered proxy target falls back to other", "/proxy/unknown/heap", "other"},
+		{"registered lookup profile", "/goroutine", "/goroutine"},
+		{"unmatched path falls back to other", "/does-not-exist", "other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.routeLabel(tt.path); got != tt.want {
+				t.Errorf("routeLabel(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
@@ -53,3 +53,21 @@ func WithErrorHandler(handlerFunc func(error)) func(*Profiler) {
 		p.errorHandler = handlerFunc
 	}
 }
+
+// WithBlockProfileRate enables block profiling and sets its sampling rate to
+// rate, as per runtime.SetBlockProfileRate. The rate is applied when Run is
+// called
+func WithBlockProfileRate(rate int) func(*Profiler) {
+	return func(p *Profiler) {
+		p.blockProfileRate = &rate
+	}
+}
+
+// WithMutexProfileFraction enables mutex profiling and sets its sampling
+// fraction to fraction, as per runtime.SetMutexProfileFraction. The fraction
+// is applied when Run is called
+func WithMutexProfileFraction(fraction int) func(*Profiler) {
+	return func(p *Profiler) {
+		p.mutexProfileFraction = &fraction
+	}
+}
@@ -6,7 +6,12 @@
 // web frontend.
 package profiler
 
-import "net/http"
+import (
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+)
 
 // defaultAddr is the default address (ip:port) to listen on
 const defaultAddr = "127.0.0.1:6060"
@@ -22,6 +27,22 @@ type Profiler struct {
 	certFile, keyFile string                          // TLS key/certificate files
 	htmlTemplate      string                          // HTML template for index page
 	errorHandler      func(error)                     // error handler function for critical frontend issues
+	continuous        *ContinuousConfig               // optional continuous background profiling configuration
+
+	proxyMutex   sync.RWMutex      // guards proxyTargets / proxyClient
+	proxyTargets map[string]string // registered remote targets, keyed by name, for proxy mode
+	proxyClient  *http.Client      // HTTP client used to reach proxy targets
+	proxyTimeout time.Duration     // per-request timeout applied to proxied requests
+	proxyRetries int               // number of additional attempts made against a target before giving up
+
+	blockProfileRate     *int // rate passed to runtime.SetBlockProfileRate on Run, if set
+	mutexProfileFraction *int // fraction passed to runtime.SetMutexProfileFraction on Run, if set
+
+	cpuProfileMu sync.Mutex // serializes pprof.StartCPUProfile/StopCPUProfile between Profile and continuous CPU capture, since the runtime only allows one active CPU profile at a time
+
+	profileAllowlist map[string]bool // if non-nil, restricts which named lookup profiles may be served
+
+	metrics *metricsRegistry // backing state for the "/metrics" endpoint
 }
 
 // New creates and returns a new debugger instance
@@ -34,6 +55,7 @@ func New(options ...func(*Profiler)) *Profiler {
 		},
 		htmlTemplate: defaultHTMLTemplate,
 		errorHandler: defaultErrorHandler,
+		metrics:      newMetricsRegistry(),
 	}
 
 	// Execute functional options (if any), see options.go for implementation
@@ -51,6 +73,13 @@ func New(options ...func(*Profiler)) *Profiler {
 // the specified action if there is any issue inside the goroutine). TLS is used
 // in case key and certificate are provided
 func (p *Profiler) Run() {
+	if p.blockProfileRate != nil {
+		runtime.SetBlockProfileRate(*p.blockProfileRate)
+	}
+	if p.mutexProfileFraction != nil {
+		runtime.SetMutexProfileFraction(*p.mutexProfileFraction)
+	}
+
 	go func() {
 		if p.certFile != "" && p.keyFile != "" {
 			p.errorHandler(p.server.ListenAndServeTLS(p.certFile, p.keyFile))
@@ -58,6 +87,10 @@ func (p *Profiler) Run() {
 			p.errorHandler(p.server.ListenAndServe())
 		}
 	}()
+
+	if p.continuous != nil {
+		go p.runContinuous()
+	}
 }
 
 func (p *Profiler) registerHandlers() {
@@ -71,12 +104,22 @@ func (p *Profiler) registerHandlers() {
 	muxer.HandleFunc("/cmdline", http.HandlerFunc(p.Cmdline))
 	muxer.HandleFunc("/symbol", http.HandlerFunc(p.Symbol))
 	muxer.HandleFunc("/trace", http.HandlerFunc(p.Trace))
+	muxer.HandleFunc("/proxy/", http.HandlerFunc(p.Proxy))
+	muxer.HandleFunc("/allocs", http.HandlerFunc(p.Allocs))
+	muxer.HandleFunc("/block", http.HandlerFunc(p.Block))
+	muxer.HandleFunc("/mutex", http.HandlerFunc(p.Mutex))
+	muxer.HandleFunc("/threadcreate", http.HandlerFunc(p.Threadcreate))
+	muxer.HandleFunc("/metrics", http.HandlerFunc(p.Metrics))
+
+	// Record per-handler request counters/latencies, then guard against
+	// MIME-sniffing, regardless of any custom middleware
+	wrapped := securityHeaders(p.instrument(muxer))
 
 	// Set custom middleware (if provided)
 	if p.middleware == nil {
-		p.server.Handler = muxer
+		p.server.Handler = wrapped
 	} else {
-		p.server.Handler = p.middleware(muxer)
+		p.server.Handler = p.middleware(wrapped)
 	}
 }
 
@@ -92,6 +135,8 @@ const defaultHTMLTemplate = `<html>
 <table>
 <tr><td align=left>plain text<td>(<a href="profile?seconds=5">5s</a> <a href="profile?seconds=15">15s</a> <a href="profile?seconds=30">30s</a> <a href="profile?seconds=60">1min</a>)
 <tr><td align=left>binary<td>(<a href="profile?seconds=5&binary=true">5s</a> <a href="profile?seconds=15&binary=true">15s</a> <a href="profile?seconds=30&binary=true">30s</a> <a href="profile?seconds=60&binary=true">1min</a>)
+<tr><td align=left>svg flamegraph<td>(<a href="profile?seconds=30&format=svg">30s</a>)
+<tr><td align=left>dot / callgrind / proto<td>(<a href="profile?seconds=30&format=dot">dot</a> <a href="profile?seconds=30&format=callgrind">callgrind</a> <a href="profile?seconds=30&format=proto">proto</a>)
 </table>
 <br>
 <b>Execution trace:</b><br>
@@ -99,13 +144,32 @@ const defaultHTMLTemplate = `<html>
 <tr><td align=left>binary<td>(<a href="trace?seconds=0.1">0.1s</a> <a href="trace?seconds=0.5">0.5s</a> <a href="trace?seconds=1.0">1.0s</a>)
 </table>
 <br>
+<b>Runtime profiles (delta supported via &amp;seconds=N):</b><br>
+<table>
+<tr><td align=left><a href="allocs?debug=1">allocs</a><td>(<a href="allocs?seconds=30">30s delta</a>)
+<tr><td align=left><a href="block?debug=1">block</a><td>(<a href="block?seconds=30">30s delta</a>)
+<tr><td align=left><a href="mutex?debug=1">mutex</a><td>(<a href="mutex?seconds=30">30s delta</a>)
+<tr><td align=left><a href="threadcreate?debug=1">threadcreate</a><td>(<a href="threadcreate?seconds=30">30s delta</a>)
+</table>
+<br>
 <b>Available default profiles:</b><br>
 <table>
-{{range .}}
+{{range .Profiles}}
 <tr><td align=left><a href="{{.Name}}?debug=1">{{.Name}}</a><td> ({{.Count}})
 {{end}}
 <tr><td align=left><a href="goroutine?debug=2">stack dump</a>
 </table>
+{{if .ProxyTargets}}
+<br>
+<b>Proxy targets:</b><br>
+<table>
+{{range .ProxyTargets}}
+<tr><td align=left>{{.}}<td> (<a href="proxy/{{.}}/profile?seconds=30">profile</a> <a href="proxy/{{.}}/heap?debug=1">heap</a>)
+{{end}}
+</table>
+{{end}}
+<br>
+<b><a href="metrics">Metrics</a></b> (Prometheus text exposition format)
 </body>
 </html>
 `
@@ -0,0 +1,184 @@
+// Copyright 2017 Fabian Kohn. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package profiler defines and manages the basic profiling commands and the
+// web frontend.
+package profiler
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// defaultProxyTimeout is the default per-request timeout applied to proxied
+// requests when none is configured via WithProxyTimeout
+const defaultProxyTimeout = 30 * time.Second
+
+// WithProxyTargets registers a fleet of remote profiling endpoints, keyed by
+// a short name, that can be browsed and pulled through this Profiler's own
+// "/proxy/{name}/..." routes (e.g. "/proxy/{name}/profile",
+// "/proxy/{name}/heap", "/proxy/{name}/symbol", "/proxy/{name}/cmdline").
+// Targets are addresses (host:port) serving this module's own routes (or a
+// compatible "/debug/pprof/"-style handler mounted at the root of addr).
+func WithProxyTargets(targets map[string]string) func(*Profiler) {
+	return func(p *Profiler) {
+		p.proxyTargets = make(map[string]string, len(targets))
+		for name, addr := range targets {
+			p.proxyTargets[name] = addr
+		}
+	}
+}
+
+// WithProxyTimeout sets the per-request timeout applied when proxying a
+// request to a remote target
+func WithProxyTimeout(timeout time.Duration) func(*Profiler) {
+	return func(p *Profiler) {
+		p.proxyTimeout = timeout
+	}
+}
+
+// WithProxyRetries sets the number of additional attempts made against a
+// remote target before giving up and returning an error to the caller.
+// Negative values are clamped to 0, since Proxy always makes at least one
+// attempt.
+func WithProxyRetries(retries int) func(*Profiler) {
+	if retries < 0 {
+		retries = 0
+	}
+	return func(p *Profiler) {
+		p.proxyRetries = retries
+	}
+}
+
+// AddTarget registers (or replaces) a single proxy target at runtime
+func (p *Profiler) AddTarget(name, addr string) {
+	p.proxyMutex.Lock()
+	defer p.proxyMutex.Unlock()
+
+	if p.proxyTargets == nil {
+		p.proxyTargets = make(map[string]string)
+	}
+	p.proxyTargets[name] = addr
+}
+
+// RemoveTarget de-registers a proxy target at runtime
+func (p *Profiler) RemoveTarget(name string) {
+	p.proxyMutex.Lock()
+	defer p.proxyMutex.Unlock()
+
+	delete(p.proxyTargets, name)
+}
+
+// target looks up the address registered for name
+func (p *Profiler) target(name string) (string, bool) {
+	p.proxyMutex.RLock()
+	defer p.proxyMutex.RUnlock()
+
+	addr, ok := p.proxyTargets[name]
+	return addr, ok
+}
+
+// targetNames returns the list of currently registered proxy target names,
+// in no particular order (Go map iteration order), used to populate the
+// Index page's target selector
+func (p *Profiler) targetNames() []string {
+	p.proxyMutex.RLock()
+	defer p.proxyMutex.RUnlock()
+
+	names := make([]string, 0, len(p.proxyTargets))
+	for name := range p.proxyTargets {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Proxy streams a request matching "/proxy/{name}/{path}" to the
+// corresponding registered target's "/{path}", preserving the method, query
+// parameters (including "seconds", "debug", "gc") and request body.
+func (p *Profiler) Proxy(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/proxy/")
+	parts := strings.SplitN(rest, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		serveError(w, http.StatusNotFound, "Malformed proxy request, expected /proxy/{name}/{path}")
+		return
+	}
+
+	name, subPath := parts[0], parts[1]
+	addr, ok := p.target(name)
+	if !ok {
+		serveError(w, http.StatusNotFound, "Unknown proxy target")
+		return
+	}
+
+	upstreamURL := fmt.Sprintf("http://%s/%s", addr, subPath)
+	if r.URL.RawQuery != "" {
+		upstreamURL += "?" + r.URL.RawQuery
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, _ = io.ReadAll(r.Body)
+	}
+
+	client := p.proxyClientOrDefault()
+
+	var (
+		resp *http.Response
+		err  error
+	)
+	for attempt := 0; attempt <= p.proxyRetries; attempt++ {
+		var req *http.Request
+		req, err = http.NewRequestWithContext(r.Context(), r.Method, upstreamURL, bytes.NewReader(body))
+		if err != nil {
+			serveError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to build proxy request: %s", err))
+			return
+		}
+		req.Header = r.Header.Clone()
+
+		resp, err = client.Do(req)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		// Report only the underlying transport error: the *url.Error wrapper
+		// carries the caller-supplied request path and query
+		if ue, ok := err.(*url.Error); ok {
+			err = ue.Err
+		}
+		serveError(w, http.StatusBadGateway, fmt.Sprintf("Failed to reach proxy target %q: %s", name, err))
+		return
+	}
+	defer resp.Body.Close()
+
+	for k, values := range resp.Header {
+		for _, v := range values {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// proxyClientOrDefault returns the http.Client used to reach proxy targets,
+// constructing one on first use with the timeout set via WithProxyTimeout
+// (or defaultProxyTimeout if none was set)
+func (p *Profiler) proxyClientOrDefault() *http.Client {
+	p.proxyMutex.Lock()
+	defer p.proxyMutex.Unlock()
+
+	if p.proxyClient == nil {
+		timeout := p.proxyTimeout
+		if timeout == 0 {
+			timeout = defaultProxyTimeout
+		}
+		p.proxyClient = &http.Client{Timeout: timeout}
+	}
+	return p.proxyClient
+}
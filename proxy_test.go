@@ -0,0 +1,55 @@
+// Copyright 2017 Fabian Kohn. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package profiler
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithProxyRetriesClampsNegativeValues(t *testing.T) {
+	p := New(WithProxyTargets(map[string]string{"remote": "127.0.0.1:0"}), WithProxyRetries(-1))
+	if p.proxyRetries != 0 {
+		t.Errorf("proxyRetries = %d, want 0", p.proxyRetries)
+	}
+
+	// A negative retry count used to leave the Proxy retry loop body never
+	// executing, leaving resp nil and panicking on defer resp.Body.Close().
+	// With the clamp in place, the loop runs at least once and Proxy should
+	// fail gracefully instead of panicking.
+	req := httptest.NewRequest("GET", "/proxy/remote/heap", nil)
+	rec := httptest.NewRecorder()
+	p.Proxy(rec, req)
+
+	if rec.Code != 502 {
+		t.Errorf("status = %d, want 502 (bad gateway, unreachable target)", rec.Code)
+	}
+}
+
+func TestProxyMalformedPath(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		want int
+	}{
+		{"missing sub-path", "/proxy/remote", 404},
+		{"empty target name", "/proxy//heap", 404},
+		{"unknown target", "/proxy/unknown/heap", 404},
+	}
+
+	p := New(WithProxyTargets(map[string]string{"remote": "127.0.0.1:0"}))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			rec := httptest.NewRecorder()
+			p.Proxy(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2017 Fabian Kohn. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package profiler defines and manages the basic profiling commands and the
+// web frontend.
+package profiler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strconv"
+
+	"github.com/fako1024/profiler/internal/profile"
+	"github.com/fako1024/profiler/internal/report"
+)
+
+// reportFormats maps the "?format=" query parameter accepted by Profile to
+// the corresponding internal/report output format. "svg" is handled
+// separately, by rendering "dot" output through Graphviz.
+//
+// "top" is not a distinct rendering: internal/report has no top-N output
+// format of its own (pprof's CLI "top" command builds its summary outside
+// this package), so "?format=top" is currently just an alias for "text"
+// rather than a real top-N listing.
+var reportFormats = map[string]int{
+	"text":      report.Text,
+	"top":       report.Text, // alias for "text"; see doc comment above
+	"tree":      report.Tree,
+	"dot":       report.Dot,
+	"callgrind": report.Callgrind,
+	"proto":     report.Proto,
+	"traces":    report.Traces,
+}
+
+// reportContentTypes holds the Content-Type to send for each supported
+// "?format=" value
+var reportContentTypes = map[string]string{
+	"text":      "text/plain; charset=utf-8",
+	"top":       "text/plain; charset=utf-8",
+	"tree":      "text/plain; charset=utf-8",
+	"dot":       "text/vnd.graphviz",
+	"callgrind": "application/octet-stream",
+	"proto":     "application/octet-stream",
+	"traces":    "text/plain; charset=utf-8",
+	"svg":       "image/svg+xml",
+}
+
+// renderReport renders prof as requested by r's "?format=", "?nodecount=",
+// "?nodefraction=", "?edgefraction=" and "?focus=" / "?ignore=" query
+// parameters, writing the result (and an appropriate Content-Type) to w.
+func (p *Profiler) renderReport(w http.ResponseWriter, r *http.Request, prof *profile.Profile, cum bool) error {
+	format := r.FormValue("format")
+	if format == "" {
+		format = "text"
+	}
+
+	focus, ignore, err := compileFocusIgnore(r)
+	if err != nil {
+		return err
+	}
+	if focus != nil || ignore != nil {
+		prof.FilterSamplesByName(focus, ignore, nil, nil)
+	}
+
+	opts := report.Options{
+		CumSort: cum,
+	}
+	if v, err := strconv.Atoi(r.FormValue("nodecount")); err == nil {
+		opts.NodeCount = v
+	}
+	if v, err := strconv.ParseFloat(r.FormValue("nodefraction"), 64); err == nil {
+		opts.NodeFraction = v
+	}
+	if v, err := strconv.ParseFloat(r.FormValue("edgefraction"), 64); err == nil {
+		opts.EdgeFraction = v
+	}
+
+	if format == "svg" {
+		return p.renderSVG(w, prof, opts)
+	}
+
+	outFmt, ok := reportFormats[format]
+	if !ok {
+		return errors.New("unsupported format")
+	}
+	opts.OutputFormat = outFmt
+
+	var buf bytes.Buffer
+	rpt := report.NewDefault(prof, opts)
+	if err := report.Generate(&buf, rpt, nil); err != nil {
+		return fmt.Errorf("failed to generate report: %w", err)
+	}
+
+	w.Header().Set("Content-Type", reportContentTypes[format])
+	w.Write(buf.Bytes())
+	return nil
+}
+
+// renderSVG renders prof as Graphviz "dot" output and pipes it through the
+// "dot" binary to produce an SVG, writing a 501 with setup instructions if
+// "dot" is not available in PATH
+func (p *Profiler) renderSVG(w http.ResponseWriter, prof *profile.Profile, opts report.Options) error {
+	dotPath, err := exec.LookPath("dot")
+	if err != nil {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusNotImplemented)
+		fmt.Fprintln(w, "SVG rendering requires the Graphviz \"dot\" binary in PATH; install graphviz, or request ?format=dot and render it locally")
+		return nil
+	}
+
+	opts.OutputFormat = report.Dot
+
+	var dotBuf bytes.Buffer
+	rpt := report.NewDefault(prof, opts)
+	if err := report.Generate(&dotBuf, rpt, nil); err != nil {
+		return fmt.Errorf("failed to generate dot report: %w", err)
+	}
+
+	var svgBuf, stderr bytes.Buffer
+	cmd := exec.Command(dotPath, "-Tsvg")
+	cmd.Stdin = &dotBuf
+	cmd.Stdout = &svgBuf
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("dot -Tsvg failed: %w (%s)", err, stderr.String())
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Write(svgBuf.Bytes())
+	return nil
+}
+
+// compileFocusIgnore compiles the "?focus=" and "?ignore=" regular
+// expressions from r, if present
+func compileFocusIgnore(r *http.Request) (focus, ignore *regexp.Regexp, err error) {
+	if v := r.FormValue("focus"); v != "" {
+		if focus, err = regexp.Compile(v); err != nil {
+			return nil, nil, errors.New("invalid focus regex")
+		}
+	}
+	if v := r.FormValue("ignore"); v != "" {
+		if ignore, err = regexp.Compile(v); err != nil {
+			return nil, nil, errors.New("invalid ignore regex")
+		}
+	}
+	return focus, ignore, nil
+}
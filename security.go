@@ -0,0 +1,76 @@
+// Copyright 2017 Fabian Kohn. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package profiler defines and manages the basic profiling commands and the
+// web frontend.
+package profiler
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// WithProfileAllowlist restricts the set of named lookup profiles (as
+// served by the Profiler's "/{name}", "/allocs", "/block", "/mutex" and
+// "/threadcreate" routes) that may be requested, mirroring setups that only
+// want to expose e.g. "heap" and "allocs" in production. A nil or empty
+// allowlist (the default) permits all registered profiles. The package-level
+// Handler is not affected by the allowlist.
+func WithProfileAllowlist(names []string) func(*Profiler) {
+	return func(p *Profiler) {
+		allowed := make(map[string]bool, len(names))
+		for _, name := range names {
+			allowed[name] = true
+		}
+		p.profileAllowlist = allowed
+	}
+}
+
+// profileAllowed reports whether name may be served, given any allowlist
+// configured via WithProfileAllowlist. A nil or zero-length allowlist
+// permits all profiles.
+func (p *Profiler) profileAllowed(name string) bool {
+	if len(p.profileAllowlist) == 0 {
+		return true
+	}
+	return p.profileAllowlist[name]
+}
+
+// serveError writes status and msg as a plain-text error response. msg must
+// never contain unsanitized user-supplied input (see securityHeaders, which
+// sets X-Content-Type-Options to guard against the response being sniffed
+// as anything other than plain text). Any Content-Disposition set by the
+// handler for a not-yet-started binary download is cleared, so a failure
+// response is never forced into a downloaded file.
+func serveError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Del("Content-Disposition")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintln(w, msg)
+}
+
+// securityHeaders wraps next, setting X-Content-Type-Options on every
+// response so that browsers never sniff a profiling response (which may
+// embed attacker-influenced strings, e.g. symbol names) as HTML
+func securityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setAttachment marks the response as a binary download with the given
+// filename, so that browsers save profile/trace payloads instead of trying
+// to render them inline
+func setAttachment(w http.ResponseWriter, filename string) {
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+}
+
+// durationExceedsWriteTimeout reports whether d would run past the
+// configured http.Server.WriteTimeout, which would otherwise silently
+// truncate a /profile or /trace capture
+func (p *Profiler) durationExceedsWriteTimeout(d time.Duration) bool {
+	return p.server.WriteTimeout != 0 && d >= p.server.WriteTimeout
+}
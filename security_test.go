@@ -0,0 +1,55 @@
+// Copyright 2017 Fabian Kohn. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package profiler
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestProfileAllowed(t *testing.T) {
+	tests := []struct {
+		name      string
+		allowlist []string
+		profile   string
+		want      bool
+	}{
+		{"no allowlist permits anything", nil, "heap", true},
+		{"empty allowlist permits anything", []string{}, "heap", true},
+		{"allowlisted profile is permitted", []string{"heap", "allocs"}, "heap", true},
+		{"non-allowlisted profile is forbidden", []string{"heap"}, "allocs", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(WithProfileAllowlist(tt.allowlist))
+			if got := p.profileAllowed(tt.profile); got != tt.want {
+				t.Errorf("profileAllowed(%q) = %v, want %v", tt.profile, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDurationExceedsWriteTimeout(t *testing.T) {
+	tests := []struct {
+		name         string
+		writeTimeout time.Duration
+		duration     time.Duration
+		want         bool
+	}{
+		{"zero WriteTimeout never exceeds", 0, time.Hour, false},
+		{"duration below timeout does not exceed", 30 * time.Second, 10 * time.Second, false},
+		{"duration equal to timeout exceeds", 30 * time.Second, 30 * time.Second, true},
+		{"duration above timeout exceeds", 30 * time.Second, time.Minute, true},
+	}
+
+	for _, tt := range tests {
+		p := &Profiler{server: &http.Server{WriteTimeout: tt.writeTimeout}}
+		if got := p.durationExceedsWriteTimeout(tt.duration); got != tt.want {
+			t.Errorf("%s: durationExceedsWriteTimeout(%s) with WriteTimeout %s = %v, want %v", tt.name, tt.duration, tt.writeTimeout, got, tt.want)
+		}
+	}
+}
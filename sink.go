@@ -0,0 +1,101 @@
+// Copyright 2017 Fabian Kohn. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package profiler defines and manages the basic profiling commands and the
+// web frontend.
+package profiler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Sink is the destination a continuously captured profile is handed off to.
+// Implementations may persist profiles locally, ship them to object storage
+// or forward them to a Pyroscope / Parca-style ingest endpoint.
+type Sink interface {
+	Write(ctx context.Context, profileName string, ts time.Time, labels map[string]string, r io.Reader) error
+}
+
+// FileSink is a Sink that writes profiles into a local directory, one file
+// per capture, named "<profileName>-<unix-nano-timestamp>.pprof".
+type FileSink struct {
+	dir string
+}
+
+// NewFileSink creates a new FileSink rooted at dir. The directory is created
+// (including parents) if it does not yet exist.
+func NewFileSink(dir string) (*FileSink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create sink directory %s: %w", dir, err)
+	}
+	return &FileSink{dir: dir}, nil
+}
+
+// Write implements the Sink interface, ignoring labels (they are not
+// representable in a plain pprof file and are left to callers that require
+// them to use an HTTPSink / Pyroscope-style ingest instead).
+func (s *FileSink) Write(_ context.Context, profileName string, ts time.Time, _ map[string]string, r io.Reader) error {
+	path := filepath.Join(s.dir, fmt.Sprintf("%s-%d.pprof", profileName, ts.UnixNano()))
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create profile file %s: %w", path, err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return fmt.Errorf("failed to write profile file %s: %w", path, err)
+	}
+	return nil
+}
+
+// HTTPSink is a Sink that POSTs captured profiles to a remote ingest URL
+// (e.g. a Pyroscope or Parca server), passing along the profile name, the
+// capture timestamp and the label set as query parameters.
+type HTTPSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPSink creates a new HTTPSink posting profiles to url using client.
+// If client is nil, http.DefaultClient is used.
+func NewHTTPSink(url string, client *http.Client) *HTTPSink {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPSink{url: url, client: client}
+}
+
+// Write implements the Sink interface.
+func (s *HTTPSink) Write(ctx context.Context, profileName string, ts time.Time, labels map[string]string, r io.Reader) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, r)
+	if err != nil {
+		return fmt.Errorf("failed to build ingest request for %s: %w", profileName, err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	q := req.URL.Query()
+	q.Set("name", profileName)
+	q.Set("ts", ts.Format(time.RFC3339Nano))
+	for k, v := range labels {
+		q.Set(k, v)
+	}
+	req.URL.RawQuery = q.Encode()
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to ship profile %s: %w", profileName, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("ingest endpoint rejected profile %s: %s", profileName, resp.Status)
+	}
+	return nil
+}